@@ -0,0 +1,91 @@
+package gohubbub
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+)
+
+// HashAlg identifies a WebSub signature algorithm. It's used both to tell a
+// hub which digest a subscription wants payloads signed with, and as the
+// minimum strength required when verifying inbound signatures.
+//
+// SHA256 is first, and so is the zero value, so that a SubscribeOptions left
+// at its default (as Go idiom encourages) requests sha256 rather than
+// silently falling back to the weakest algorithm, sha1.
+//
+// https://www.w3.org/TR/websub/#recognized-algorithm-names
+type HashAlg int
+
+const (
+	SHA256 HashAlg = iota
+	SHA1
+	SHA384
+	SHA512
+)
+
+// String returns the WebSub wire name for the algorithm, e.g. "sha256".
+func (a HashAlg) String() string {
+	switch a {
+	case SHA1:
+		return "sha1"
+	case SHA256:
+		return "sha256"
+	case SHA384:
+		return "sha384"
+	case SHA512:
+		return "sha512"
+	default:
+		return "unknown"
+	}
+}
+
+// hashFunc returns the hash constructor for the algorithm, defaulting to
+// sha256 for an unrecognized value.
+func (a HashAlg) hashFunc() func() hash.Hash {
+	switch a {
+	case SHA1:
+		return sha1.New
+	case SHA384:
+		return sha512.New384
+	case SHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+// strength ranks algorithms from weakest to strongest, so verification can
+// reject a signature weaker than what was requested at subscribe time.
+func (a HashAlg) strength() int {
+	switch a {
+	case SHA1:
+		return 1
+	case SHA256:
+		return 2
+	case SHA384:
+		return 3
+	case SHA512:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// parseHashAlg maps a WebSub wire name to a HashAlg, reporting false if name
+// isn't recognized.
+func parseHashAlg(name string) (HashAlg, bool) {
+	switch name {
+	case "sha1":
+		return SHA1, true
+	case "sha256":
+		return SHA256, true
+	case "sha384":
+		return SHA384, true
+	case "sha512":
+		return SHA512, true
+	default:
+		return SHA1, false
+	}
+}
@@ -0,0 +1,56 @@
+package gohubbub
+
+import "testing"
+
+func TestHashAlgZeroValueIsSHA256(t *testing.T) {
+	var opts SubscribeOptions
+	if opts.Algorithm != SHA256 {
+		t.Errorf("zero-value SubscribeOptions.Algorithm = %s, want sha256", opts.Algorithm)
+	}
+}
+
+func TestHashAlgString(t *testing.T) {
+	cases := map[HashAlg]string{
+		SHA1:          "sha1",
+		SHA256:        "sha256",
+		SHA384:        "sha384",
+		SHA512:        "sha512",
+		HashAlg(1000): "unknown",
+	}
+	for alg, want := range cases {
+		if got := alg.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", alg, got, want)
+		}
+	}
+}
+
+func TestHashAlgStrengthOrdering(t *testing.T) {
+	algs := []HashAlg{SHA1, SHA256, SHA384, SHA512}
+	for i := 1; i < len(algs); i++ {
+		if algs[i].strength() <= algs[i-1].strength() {
+			t.Errorf("%s.strength() = %d, want > %s.strength() = %d",
+				algs[i], algs[i].strength(), algs[i-1], algs[i-1].strength())
+		}
+	}
+}
+
+func TestParseHashAlg(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   HashAlg
+		wantOk bool
+	}{
+		{"sha1", SHA1, true},
+		{"sha256", SHA256, true},
+		{"sha384", SHA384, true},
+		{"sha512", SHA512, true},
+		{"sha3", SHA1, false},
+		{"", SHA1, false},
+	}
+	for _, c := range cases {
+		got, ok := parseHashAlg(c.name)
+		if got != c.want || ok != c.wantOk {
+			t.Errorf("parseHashAlg(%q) = (%s, %v), want (%s, %v)", c.name, got, ok, c.want, c.wantOk)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gohubbub
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultDedupCacheSize is the number of digests a Client's default
+// DedupCache remembers.
+const DefaultDedupCacheSize = 256
+
+// DefaultDedupTTL is how long a digest is remembered by a Client's default
+// DedupCache before it's treated as unseen again.
+const DefaultDedupTTL = 10 * time.Minute
+
+// DedupCache decides whether an update has already been delivered, so
+// duplicate hub deliveries for the same subscription can be dropped. digest
+// is a content hash computed by the caller; implementations just need to
+// remember which digests they've seen.
+type DedupCache interface {
+	// Seen records digest and reports whether it had already been seen.
+	Seen(digest string) bool
+}
+
+// lruDedupCache is a fixed-capacity, time-expiring LRU used as the default
+// DedupCache. Client keeps one lruDedupCache per topic (see
+// Client.dedupFor), keyed by a digest of (topic, contentType, body), so a
+// busy topic can't evict a quiet topic's recent history.
+type lruDedupCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type dedupEntry struct {
+	digest string
+	seenAt time.Time
+}
+
+// newLRUDedupCache returns a DedupCache that remembers up to capacity
+// digests, each expiring ttl after it was last seen.
+func newLRUDedupCache(capacity int, ttl time.Duration) *lruDedupCache {
+	return &lruDedupCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *lruDedupCache) Seen(digest string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := c.entries[digest]; ok {
+		entry := el.Value.(*dedupEntry)
+		if now.Sub(entry.seenAt) <= c.ttl {
+			c.order.MoveToFront(el)
+			entry.seenAt = now
+			return true
+		}
+		// Expired; fall through and treat it as a fresh digest.
+		c.order.Remove(el)
+		delete(c.entries, digest)
+	}
+
+	c.order.PushFront(&dedupEntry{digest: digest, seenAt: now})
+	c.entries[digest] = c.order.Front()
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dedupEntry).digest)
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package gohubbub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUDedupCacheSeen(t *testing.T) {
+	c := newLRUDedupCache(10, time.Minute)
+
+	if c.Seen("a") {
+		t.Fatal("first Seen(a) = true, want false")
+	}
+	if !c.Seen("a") {
+		t.Fatal("second Seen(a) = false, want true")
+	}
+}
+
+func TestLRUDedupCacheCapacityEviction(t *testing.T) {
+	c := newLRUDedupCache(2, time.Minute)
+
+	c.Seen("a")
+	c.Seen("b")
+	c.Seen("c") // evicts "a", the least recently used
+
+	// Check "b" (expected present) before "a" (expected evicted): Seen
+	// records every digest it's given, even on a miss, so checking the
+	// evicted one first would itself re-insert it and evict "b" instead.
+	if !c.Seen("b") {
+		t.Fatal("Seen(b) = false, want true (should still be remembered)")
+	}
+	if c.Seen("a") {
+		t.Fatal("Seen(a) = true after eviction, want false (should be forgotten)")
+	}
+}
+
+func TestLRUDedupCacheMoveToFrontOnSeen(t *testing.T) {
+	c := newLRUDedupCache(2, time.Minute)
+
+	c.Seen("a")
+	c.Seen("b")
+	c.Seen("a") // touches "a", so "b" becomes the least recently used
+	c.Seen("c") // should evict "b", not "a"
+
+	if !c.Seen("a") {
+		t.Fatal("Seen(a) = false, want true (recently touched, shouldn't be evicted)")
+	}
+}
+
+func TestLRUDedupCacheTTLExpiry(t *testing.T) {
+	c := newLRUDedupCache(10, time.Millisecond)
+
+	c.Seen("a")
+	time.Sleep(5 * time.Millisecond)
+
+	if c.Seen("a") {
+		t.Fatal("Seen(a) = true after TTL expiry, want false (should be treated as fresh)")
+	}
+}
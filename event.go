@@ -0,0 +1,135 @@
+package gohubbub
+
+import "sync"
+
+// Update carries a single notification delivered by a hub for a topic.
+type Update struct {
+	Topic       string // The topic the update was received for.
+	ContentType string // Content-Type of the notification body.
+	Body        []byte // The raw notification body.
+	HubLink     string // Link header rel="hub" sent with the update, if any.
+	SelfLink    string // Link header rel="self" sent with the update, if any.
+}
+
+// Subscription represents a single consumer's interest in a topic's
+// updates. It's returned by Client.Subscribe; multiple Subscriptions can
+// exist for the same topic, each receiving every update independently.
+// Call Unsubscribe when the Subscription is no longer needed.
+//
+// Both Updates and Err are delivered on a capacity-1 channel with a
+// non-blocking send: a Subscription that hasn't drained the previous value
+// before the next one arrives silently misses it, with no error or count to
+// signal that a drop happened. Consumers that can't guarantee they'll keep
+// up should poll frequently or accept that delivery is best-effort.
+type Subscription struct {
+	updates chan Update
+	err     chan error
+	unsub   func()
+	once    sync.Once
+}
+
+// Updates returns the channel updates are delivered on. See the Subscription
+// doc for the at-most-once, drop-if-not-drained delivery contract.
+func (s *Subscription) Updates() <-chan Update {
+	return s.updates
+}
+
+// Err returns a channel that delivery or verification errors are sent on.
+// See the Subscription doc for the at-most-once, drop-if-not-drained
+// delivery contract.
+func (s *Subscription) Err() <-chan error {
+	return s.err
+}
+
+// Unsubscribe stops delivery to this Subscription and closes its channels.
+// It's safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(s.unsub)
+}
+
+// topicFeed fans out updates for a single topic to every active
+// Subscription, so handlers no longer silently overwrite one another.
+type topicFeed struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func newTopicFeed() *topicFeed {
+	return &topicFeed{subs: make(map[*Subscription]struct{})}
+}
+
+// subscribe registers a new Subscription on the feed. onEmpty, if non-nil,
+// is called once the last Subscription on the feed unsubscribes.
+func (f *topicFeed) subscribe(onEmpty func()) *Subscription {
+	s := &Subscription{
+		updates: make(chan Update, 1),
+		err:     make(chan error, 1),
+	}
+
+	f.mu.Lock()
+	f.subs[s] = struct{}{}
+	f.mu.Unlock()
+
+	s.unsub = func() {
+		f.mu.Lock()
+		delete(f.subs, s)
+		empty := len(f.subs) == 0
+		f.mu.Unlock()
+
+		close(s.updates)
+		close(s.err)
+
+		if empty && onEmpty != nil {
+			onEmpty()
+		}
+	}
+
+	return s
+}
+
+// closeAll unsubscribes every Subscription currently on the feed, e.g. when
+// Client.Unsubscribe drops a topic entirely. Without this, a Subscription
+// whose holder never calls Unsubscribe itself would be orphaned: its
+// channels would never close and it would never receive anything again,
+// with no way for the holder to detect that.
+func (f *topicFeed) closeAll() {
+	f.mu.Lock()
+	subs := make([]*Subscription, 0, len(f.subs))
+	for s := range f.subs {
+		subs = append(subs, s)
+	}
+	f.mu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+}
+
+// send delivers u to every Subscription on the feed. Slow consumers don't
+// block delivery to others; a consumer that hasn't drained its channel
+// simply misses the update.
+func (f *topicFeed) send(u Update) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		select {
+		case s.updates <- u:
+		default:
+		}
+	}
+}
+
+// sendErr delivers err to every Subscription on the feed, e.g. when the hub
+// denies the subscription or a signature fails verification. Slow consumers
+// don't block delivery to others; a consumer that hasn't drained its Err
+// channel simply misses the error.
+func (f *topicFeed) sendErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		select {
+		case s.err <- err:
+		default:
+		}
+	}
+}
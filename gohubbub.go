@@ -2,47 +2,57 @@
 
 // Package gohubbub provides a PubSubHubbub subscriber client.  It will request
 // subscriptions from a hub and handle responses as required by the prootcol.
-// Update notifications will be forwarded to the handler function that was
-// registered on subscription.
+// Update notifications are delivered on the Subscription returned by
+// Subscribe, or to a callback registered with SubscribeWithHandler.
 package gohubbub
 
 import (
 	"bytes"
-	"container/ring"
 	"crypto/hmac"
-	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/sha512"
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
-	"hash"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Struct for storing information about a subscription.
 type subscription struct {
-	hub        string
-	topic      string
-	secret     string
-	id         int
-	handler    func(string, []byte) // Content-Type, ResponseBody
+	hub          string
+	topic        string
+	secret       string
+	id           int
+	algorithm    HashAlg // Signature algorithm requested of, and required from, the hub.
+	leaseSeconds int     // Requested lease duration; 0 lets the hub choose.
+	callback     string  // Overrides the auto-generated callback URL, if set.
+
+	// mu guards the fields below, which are read by Client.Subscriptions and
+	// mutated both by the renewal scheduler and by handleCallback.
+	mu         sync.Mutex
 	lease      time.Duration
 	verifiedAt time.Time
+	state      SubscriptionState
+	lastError  error
+	retries    int
+	timer      *time.Timer
 }
 
-func (s subscription) String() string {
+func (s *subscription) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return fmt.Sprintf("%s (#%d %s)", s.topic, s.id, s.lease)
 }
 
-func (s subscription) SecretKey() string {
+func (s *subscription) SecretKey() string {
 	mac := hmac.New(sha1.New, []byte(s.secret))
 	mac.Write([]byte(s.topic))
 	return hex.EncodeToString(mac.Sum(nil))
@@ -50,9 +60,6 @@ func (s subscription) SecretKey() string {
 
 var nilSubscription = &subscription{}
 
-// Used to create callback URLs.
-var subscriptionIdCounter = 0
-
 // A HttpRequester is used to make HTTP requests.  http.Client{} satisfies this
 // interface.
 type HttpRequester interface {
@@ -64,97 +71,344 @@ type HttpRequester interface {
 type Client struct {
 	self string // URL of subscriber host
 
-	from          string                   // String passed in the "From" header.
-	running       bool                     // Whether the server is running.
-	subscriptions map[string]*subscription // Map of subscriptions.
-	httpRequester HttpRequester            // e.g. http.Client{}.
-	history       *ring.Ring               // Stores past messages, for deduplication.
+	from          string            // String passed in the "From" header.
+	running       bool              // Whether the server is running.
+	store         SubscriptionStore // Stores subscriptions.
+	httpRequester HttpRequester     // e.g. http.Client{}.
+	newDedup      func() DedupCache // Builds a fresh DedupCache for a topic.
+
+	feedsMu sync.Mutex
+	feeds   map[string]*topicFeed // Per-topic fan-out of Updates.
+
+	dedupsMu sync.Mutex
+	dedups   map[string]DedupCache // Per-topic dedup, so a busy topic can't evict another's history.
 }
 
+// ClientOptions customizes a Client beyond the defaults NewClient uses.
+type ClientOptions struct {
+	// Store persists subscriptions; defaults to an in-memory store.
+	Store SubscriptionStore
+	// NewDedupCache builds the DedupCache used to deduplicate deliveries for
+	// a single topic; called once per topic, the first time it's needed.
+	// Defaults to an LRU cache sized by DedupCacheSize/DedupTTL.
+	NewDedupCache func() DedupCache
+	// DedupCacheSize bounds each topic's default DedupCache capacity.
+	// Ignored if NewDedupCache is set. Defaults to DefaultDedupCacheSize.
+	DedupCacheSize int
+	// DedupTTL bounds how long each topic's default DedupCache remembers a
+	// digest. Ignored if NewDedupCache is set. Defaults to DefaultDedupTTL.
+	DedupTTL time.Duration
+}
+
+// NewClient creates a Client backed by an in-memory SubscriptionStore, so
+// subscriptions don't survive a restart. Use NewClientWithStore or
+// NewClientWithOptions to customize that.
 func NewClient(self string, from string) *Client {
+	return NewClientWithOptions(self, from, ClientOptions{})
+}
+
+// NewClientWithStore creates a Client whose subscriptions are read from and
+// written through store, e.g. one returned by NewFileSubscriptionStore, so
+// lease timers, secrets and callback IDs survive process restarts. Sharing
+// them across replicas takes a store that every replica reads and writes;
+// the file store reads its file only when it's created.
+func NewClientWithStore(self string, from string, store SubscriptionStore) *Client {
+	return NewClientWithOptions(self, from, ClientOptions{Store: store})
+}
+
+// NewClientWithOptions creates a Client with full control over its
+// SubscriptionStore and DedupCache.
+func NewClientWithOptions(self string, from string, opts ClientOptions) *Client {
+	store := opts.Store
+	if store == nil {
+		store = newMemoryStore()
+	}
+
+	newDedup := opts.NewDedupCache
+	if newDedup == nil {
+		size := opts.DedupCacheSize
+		if size == 0 {
+			size = DefaultDedupCacheSize
+		}
+		ttl := opts.DedupTTL
+		if ttl == 0 {
+			ttl = DefaultDedupTTL
+		}
+		newDedup = func() DedupCache { return newLRUDedupCache(size, ttl) }
+	}
+
 	return &Client{
-		self,
-		fmt.Sprintf("%s (gohubbub)", from),
-		false,
-		make(map[string]*subscription),
-		&http.Client{}, // TODO: Use client with Timeout transport.
-		ring.New(50),
+		self:          self,
+		from:          fmt.Sprintf("%s (gohubbub)", from),
+		store:         store,
+		httpRequester: &http.Client{}, // TODO: Use client with Timeout transport.
+		newDedup:      newDedup,
+		feeds:         make(map[string]*topicFeed),
+		dedups:        make(map[string]DedupCache),
+	}
+}
+
+// feedFor returns the topicFeed for topic, creating it if necessary.
+func (client *Client) feedFor(topic string) *topicFeed {
+	client.feedsMu.Lock()
+	defer client.feedsMu.Unlock()
+	f, ok := client.feeds[topic]
+	if !ok {
+		f = newTopicFeed()
+		client.feeds[topic] = f
 	}
+	return f
+}
+
+// dedupFor returns the DedupCache for topic, creating it if necessary, so
+// that a busy topic's deliveries can't evict a quiet topic's recent history.
+func (client *Client) dedupFor(topic string) DedupCache {
+	client.dedupsMu.Lock()
+	defer client.dedupsMu.Unlock()
+	d, ok := client.dedups[topic]
+	if !ok {
+		d = client.newDedup()
+		client.dedups[topic] = d
+	}
+	return d
 }
 
 // HasSubscription returns true if a subscription exists for the topic.
 func (client *Client) HasSubscription(topic string) bool {
-	_, ok := client.subscriptions[topic]
+	_, ok := client.store.Get(topic)
 	return ok
 }
 
-// Discover queries an RSS or Atom feed for the hub which it is publishing to.
-func (client *Client) Discover(topic string) (string, error) {
+// link represents a single rel/href pair, whether it came from an HTTP Link
+// header, an RSS/Atom <link> element, or an HTML <link> element.
+type link struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// feed is a minimal RSS/Atom container, just enough to pull out the <link>
+// elements DiscoverTopic cares about.
+type feed struct {
+	Link    []link `xml:"link"`
+	Channel struct {
+		Link []link `xml:"link"`
+	} `xml:"channel"`
+}
+
+// linkHeaderRegexp matches a single RFC 5988 Link header segment, e.g.
+// `<https://example.com/hub>; rel="hub"`.
+var linkHeaderRegexp = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[a-zA-Z0-9-]+\s*=\s*"[^"]*")*)`)
+
+// linkHeaderParamRegexp matches a single `name="value"` parameter within a
+// Link header segment.
+var linkHeaderParamRegexp = regexp.MustCompile(`([a-zA-Z0-9-]+)\s*=\s*"([^"]*)"`)
+
+// htmlLinkRegexp matches <link> elements in an HTML document.
+var htmlLinkRegexp = regexp.MustCompile(`(?i)<link\s+([^>]*?)/?>`)
+
+// htmlLinkAttrRegexp matches a single attr="value" pair within a <link> tag.
+var htmlLinkAttrRegexp = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*"([^"]*)"`)
+
+// parseLinkHeader extracts rel="hub"/rel="self" style links from the value
+// of an HTTP Link header, per RFC 5988.
+func parseLinkHeader(header string) []link {
+	var links []link
+	for _, m := range linkHeaderRegexp.FindAllStringSubmatch(header, -1) {
+		href, params := m[1], m[2]
+		for _, p := range linkHeaderParamRegexp.FindAllStringSubmatch(params, -1) {
+			if p[1] == "rel" {
+				links = append(links, link{Rel: p[2], Href: href})
+			}
+		}
+	}
+	return links
+}
+
+// parseHTMLLinks extracts rel="hub"/rel="self" style links from <link>
+// elements in an HTML document, for topics that are HTML pages rather than
+// RSS or Atom feeds.
+func parseHTMLLinks(body []byte) []link {
+	var links []link
+	for _, tag := range htmlLinkRegexp.FindAllSubmatch(body, -1) {
+		attrs := map[string]string{}
+		for _, a := range htmlLinkAttrRegexp.FindAllSubmatch(tag[1], -1) {
+			attrs[strings.ToLower(string(a[1]))] = string(a[2])
+		}
+		if rel, href := attrs["rel"], attrs["href"]; rel != "" && href != "" {
+			links = append(links, link{Rel: rel, Href: href})
+		}
+	}
+	return links
+}
+
+// DiscoverTopic queries topic for the hub it publishes to and the canonical
+// "self" URL subscriptions should be registered under. As required by the
+// WebSub 0.4 spec, HTTP Link headers are checked first and take priority
+// over payload-embedded links; RSS/Atom <link> elements and HTML <link>
+// elements are used as a fallback, so hubs that only advertise one way or
+// the other both work. The returned self URL may differ from topic, which
+// fixes subscriptions being registered under a URL the hub doesn't
+// recognize when it later sends update notifications.
+func (client *Client) DiscoverTopic(topic string) (hub string, self string, err error) {
 	resp, err := http.Get(topic)
 	if err != nil {
-		return "", fmt.Errorf("unable to fetch feed, %v", err)
+		return "", "", fmt.Errorf("unable to fetch feed, %v", err)
 	}
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("feed request failed, status code %d", resp.StatusCode)
+		return "", "", fmt.Errorf("feed request failed, status code %d", resp.StatusCode)
 	}
 
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("error reading feed response, %v", err)
+		return "", "", fmt.Errorf("error reading feed response, %v", err)
 	}
 
+	links := parseLinkHeader(resp.Header.Get("Link"))
+
 	var f feed
-	if xmlError := xml.Unmarshal(body, &f); xmlError != nil {
-		return "", fmt.Errorf("unable to parse xml, %v", xmlError)
+	if xml.Unmarshal(body, &f) == nil {
+		links = append(links, append(f.Link, f.Channel.Link...)...)
 	}
-
-	links := append(f.Link, f.Channel.Link...)
-	for _, link := range links {
-		if link.Rel == "hub" {
-			return link.Href, nil
+	links = append(links, parseHTMLLinks(body)...)
+
+	self = topic
+	sawSelf := false
+	for _, l := range links {
+		// RFC 8288 allows a single link to carry a space-separated relation
+		// list, e.g. rel="hub self", so a link can match both cases below.
+		for _, rel := range strings.Fields(l.Rel) {
+			switch rel {
+			case "hub":
+				if hub == "" {
+					hub = l.Href
+				}
+			case "self":
+				if !sawSelf {
+					self = l.Href
+					sawSelf = true
+				}
+			}
 		}
 	}
 
-	return "", fmt.Errorf("no hub found in feed")
+	if hub == "" {
+		return "", "", fmt.Errorf("no hub found in feed")
+	}
+
+	return hub, self, nil
+}
+
+// Discover queries an RSS or Atom feed for the hub which it is publishing to.
+//
+// Deprecated: use DiscoverTopic, which also returns the canonical "self"
+// topic URL that subscriptions should be registered under.
+func (client *Client) Discover(topic string) (string, error) {
+	hub, _, err := client.DiscoverTopic(topic)
+	return hub, err
 }
 
 // DiscoverAndSubscribe queries an RSS or Atom feed for the hub which it is
-// publishing to, then subscribes for updates.
+// publishing to, then subscribes for updates under the canonical self URL
+// reported by discovery.
 func (client *Client) DiscoverAndSubscribe(topic, secret string, handler func(string, []byte)) error {
-	hub, err := client.Discover(topic)
+	hub, self, err := client.DiscoverTopic(topic)
 	if err != nil {
 		return fmt.Errorf("unable to find hub, %v", err)
 	}
-	client.Subscribe(hub, topic, secret, handler)
-	return nil
+	return client.SubscribeWithHandler(hub, self, secret, handler)
+}
+
+// SubscribeOptions customizes a subscription beyond the defaults Subscribe
+// uses.
+type SubscribeOptions struct {
+	// Algorithm is the signature digest requested of the hub, and the
+	// minimum strength accepted when verifying its signed updates. Weaker
+	// signatures are rejected. Defaults to SHA256.
+	Algorithm HashAlg
+	// LeaseSeconds requests a specific lease duration; hubs may ignore it.
+	LeaseSeconds int
+	// Callback overrides the auto-generated callback URL, if set.
+	Callback string
+}
+
+// Subscribe registers interest in topic's updates from hub and returns a
+// Subscription that receives them via its Updates channel. Multiple
+// Subscriptions may be created for the same topic; each receives every
+// update independently, so callers no longer silently overwrite one
+// another's handler. It requests sha256-signed updates; use
+// SubscribeWithOptions to choose a different algorithm or lease.
+func (client *Client) Subscribe(hub, topic, secret string) (*Subscription, error) {
+	return client.SubscribeWithOptions(hub, topic, secret, SubscribeOptions{Algorithm: SHA256})
 }
 
-// Subscribe adds a handler will be called when an update notification is
-// received.  If a handler already exists for the given topic it will be
-// overridden.
-func (client *Client) Subscribe(hub, topic, secret string, handler func(string, []byte)) {
-	s := &subscription{
-		hub:     hub,
-		topic:   topic,
-		secret:  secret,
-		id:      subscriptionIdCounter,
-		handler: handler,
+// SubscribeWithOptions behaves like Subscribe but allows the signature
+// algorithm, lease duration and callback URL to be customized.
+func (client *Client) SubscribeWithOptions(hub, topic, secret string, opts SubscribeOptions) (*Subscription, error) {
+	if _, exists := client.store.Get(topic); !exists {
+		s := &subscription{
+			hub:          hub,
+			topic:        topic,
+			secret:       secret,
+			id:           client.store.NextID(),
+			algorithm:    opts.Algorithm,
+			leaseSeconds: opts.LeaseSeconds,
+			callback:     opts.Callback,
+		}
+		if err := client.store.Put(s); err != nil {
+			return nil, err
+		}
+		if client.running {
+			client.makeSubscriptionRequest(s)
+		}
 	}
-	client.subscriptions[topic] = s
-	subscriptionIdCounter = subscriptionIdCounter + 1
-	if client.running {
-		client.makeSubscriptionRequest(s)
+
+	feed := client.feedFor(topic)
+	return feed.subscribe(func() {
+		client.Unsubscribe(topic)
+	}), nil
+}
+
+// SubscribeWithHandler adds a handler that will be called when an update
+// notification is received. It's a thin wrapper around Subscribe for
+// callers that prefer a callback over reading from a Subscription's Updates
+// channel; it replaces the old Subscribe(hub, topic, secret, handler) API.
+func (client *Client) SubscribeWithHandler(hub, topic, secret string, handler func(string, []byte)) error {
+	sub, err := client.Subscribe(hub, topic, secret)
+	if err != nil {
+		return err
 	}
+	go func() {
+		for update := range sub.Updates() {
+			handler(update.ContentType, update.Body)
+		}
+	}()
+	return nil
 }
 
-// Unsubscribe sends an unsubscribe notification and removes the subscription.
+// Unsubscribe sends an unsubscribe notification and removes the
+// subscription. Any Subscription still held for topic is closed too, so
+// callers that never call Subscription.Unsubscribe themselves aren't left
+// holding channels that will never receive anything again.
 func (client *Client) Unsubscribe(topic string) {
-	if s, exists := client.subscriptions[topic]; exists {
-		delete(client.subscriptions, topic)
+	if s, exists := client.store.Get(topic); exists {
+		client.store.Delete(topic)
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.mu.Unlock()
 		if client.running {
 			client.makeUnsubscribeRequeast(s)
 		}
+
+		client.feedsMu.Lock()
+		f, hasFeed := client.feeds[topic]
+		delete(client.feeds, topic)
+		client.feedsMu.Unlock()
+		if hasFeed {
+			f.closeAll()
+		}
 	} else {
 		log.Printf("Cannot unsubscribe, %s doesn't exist.", topic)
 	}
@@ -189,34 +443,34 @@ func (client *Client) Start() {
 	}
 
 	client.running = true
-	client.ensureSubscribed()
+	for _, s := range client.store.List() {
+		s.mu.Lock()
+		denied := s.state == StateDenied
+		renewAt := s.verifiedAt.Add(s.lease).Add(-renewalJitter).Sub(time.Now())
+		s.mu.Unlock()
+
+		if denied {
+			continue
+		}
+		if renewAt < 0 {
+			renewAt = 0
+		}
+		client.scheduleRenewal(s, renewAt)
+	}
 }
 
 // String provides a textual representation of the client's current state.
-func (client Client) String() string {
-	urls := make([]string, len(client.subscriptions))
-	i := 0
-	for k, _ := range client.subscriptions {
-		urls[i] = k
-		i++
-	}
-	return fmt.Sprintf("%d subscription(s): %v", len(client.subscriptions), urls)
-}
-
-func (client *Client) ensureSubscribed() {
-	for _, s := range client.subscriptions {
-		// Try to renew the subscription if the lease expires within an hour.
-		oneHourAgo := time.Now().Add(-time.Hour)
-		expireTime := s.verifiedAt.Add(s.lease)
-		if expireTime.Before(oneHourAgo) {
-			client.makeSubscriptionRequest(s)
-		}
+func (client *Client) String() string {
+	subs := client.store.List()
+	urls := make([]string, len(subs))
+	for i, s := range subs {
+		urls[i] = s.topic
 	}
-	time.AfterFunc(time.Minute, client.ensureSubscribed)
+	return fmt.Sprintf("%d subscription(s): %v", len(subs), urls)
 }
 
 func (client *Client) makeSubscriptionRequest(s *subscription) {
-	callbackUrl := client.formatCallbackURL(s.id)
+	callbackUrl := client.formatCallbackURL(s)
 
 	log.Println("Subscribing to", s.topic, "waiting for callback on", callbackUrl)
 
@@ -224,11 +478,14 @@ func (client *Client) makeSubscriptionRequest(s *subscription) {
 	body.Set("hub.callback", callbackUrl)
 	body.Add("hub.topic", s.topic)
 	body.Add("hub.mode", "subscribe")
-	// body.Add("hub.lease_seconds", "60")
+	if s.leaseSeconds > 0 {
+		body.Add("hub.lease_seconds", strconv.Itoa(s.leaseSeconds))
+	}
 	if len(s.secret) > 0 {
 		secretKey := s.SecretKey()
 		log.Printf("Use hub.secret : %s", secretKey)
 		body.Add("hub.secret", secretKey)
+		body.Add("hub.secret.algorithm", s.algorithm.String())
 	}
 
 	req, _ := http.NewRequest("POST", s.hub, bytes.NewBufferString(body.Encode()))
@@ -238,18 +495,37 @@ func (client *Client) makeSubscriptionRequest(s *subscription) {
 	resp, err := client.httpRequester.Do(req)
 
 	if err != nil {
-		log.Printf("Subscription failed, %s, %s", *s, err)
+		log.Printf("Subscription failed, %s, %s", s, err)
+		client.recordFailure(s, err, 0)
+		return
+	}
 
-	} else if resp.StatusCode != 202 {
-		log.Printf("Subscription failed, %s, status = %s", *s, resp.Status)
+	if resp.StatusCode != 202 {
+		log.Printf("Subscription failed, %s, status = %s", s, resp.Status)
+		client.recordFailure(s, fmt.Errorf("status = %s", resp.Status), parseRetryAfter(resp.Header.Get("Retry-After")))
+		return
+	}
+
+	// Accepted; wait for the hub's verification GET. If it never arrives,
+	// re-send with backoff instead of hammering a silently broken hub at a
+	// fixed interval forever.
+	s.mu.Lock()
+	s.retries++
+	retries := s.retries
+	s.mu.Unlock()
+
+	delay := verificationTimeout
+	if backoff := backoffDuration(retries); backoff > delay {
+		delay = backoff
 	}
+	client.scheduleRenewal(s, delay)
 }
 
 func (client *Client) makeUnsubscribeRequeast(s *subscription) {
 	log.Println("Unsubscribing from", s.topic)
 
 	body := url.Values{}
-	body.Set("hub.callback", client.formatCallbackURL(s.id))
+	body.Set("hub.callback", client.formatCallbackURL(s))
 	body.Add("hub.topic", s.topic)
 	body.Add("hub.mode", "unsubscribe")
 
@@ -260,15 +536,18 @@ func (client *Client) makeUnsubscribeRequeast(s *subscription) {
 	resp, err := client.httpRequester.Do(req)
 
 	if err != nil {
-		log.Printf("Unsubscribe failed, %s, %s", *s, err)
+		log.Printf("Unsubscribe failed, %s, %s", s, err)
 
 	} else if resp.StatusCode != 202 {
-		log.Printf("Unsubscribe failed, %s status = %s", *s, resp.Status)
+		log.Printf("Unsubscribe failed, %s status = %s", s, resp.Status)
 	}
 }
 
-func (client *Client) formatCallbackURL(callback int) string {
-	return fmt.Sprintf("%s/push-callback/%d", client.self, callback)
+func (client *Client) formatCallbackURL(s *subscription) string {
+	if s.callback != "" {
+		return s.callback
+	}
+	return fmt.Sprintf("%s/push-callback/%d", client.self, s.id)
 }
 
 func (client *Client) handleDefaultRequest(resp http.ResponseWriter, req *http.Request) {
@@ -291,12 +570,25 @@ func (client *Client) handleCallback(resp http.ResponseWriter, req *http.Request
 
 	switch params.Get("hub.mode") {
 	case "subscribe":
-		if s, exists := client.subscriptions[topic]; exists {
+		if s, exists := client.store.Get(topic); exists {
+			s.mu.Lock()
 			s.verifiedAt = time.Now()
 			lease, err := strconv.Atoi(params.Get("hub.lease_seconds"))
 			if err == nil {
 				s.lease = time.Second * time.Duration(lease)
 			}
+			s.state = StateActive
+			s.lastError = nil
+			s.retries = 0
+			leaseDuration := s.lease
+			s.mu.Unlock()
+			client.store.Put(s)
+
+			renewAt := leaseDuration - renewalJitter
+			if renewAt <= 0 {
+				renewAt = leaseDuration
+			}
+			client.scheduleRenewal(s, renewAt)
 
 			log.Printf("Subscription verified for %s, lease is %s", topic, s.lease)
 			resp.Write([]byte(params.Get("hub.challenge")))
@@ -309,7 +601,7 @@ func (client *Client) handleCallback(resp http.ResponseWriter, req *http.Request
 	case "unsubscribe":
 		// We optimistically removed the subscription, so only confirm the
 		// unsubscribe if no subscription exists for the topic.
-		if _, exists := client.subscriptions[topic]; !exists {
+		if _, exists := client.store.Get(topic); !exists {
 			log.Printf("Unsubscribe confirmed for %s", topic)
 			resp.Write([]byte(params.Get("hub.challenge")))
 
@@ -321,7 +613,19 @@ func (client *Client) handleCallback(resp http.ResponseWriter, req *http.Request
 	case "denied":
 		log.Printf("Subscription denied for %s, reason was %s", topic, params.Get("hub.reason"))
 		resp.Write([]byte{})
-		// TODO: Don't do anything for now, should probably mark the subscription.
+
+		if s, exists := client.store.Get(topic); exists {
+			deniedErr := fmt.Errorf("denied: %s", params.Get("hub.reason"))
+			s.mu.Lock()
+			s.state = StateDenied
+			s.lastError = deniedErr
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.mu.Unlock()
+			client.store.Put(s)
+			client.feedFor(topic).sendErr(deniedErr)
+		}
 
 	default:
 		s, exists := client.subscriptionForPath(req.URL.Path)
@@ -337,42 +641,50 @@ func (client *Client) handleCallback(resp http.ResponseWriter, req *http.Request
 				signature := strings.Split(req.Header.Get("x-hub-signature"), "=")
 				if len(signature) != 2 {
 					log.Printf("Signature not found or invalid %s", s)
+					client.feedFor(s.topic).sendErr(fmt.Errorf("signature not found or invalid for %s", s.topic))
 					http.Error(resp, "Invalid Subscription", http.StatusBadRequest)
 					return
 				}
 
-				var hashAlg func() hash.Hash
-
 				// Recognize algorithm
 				// https://www.w3.org/TR/websub/#recognized-algorithm-names
-				switch signature[0] {
-				case "sha1":
-					hashAlg = sha1.New
-				case "sha256":
-					hashAlg = sha256.New
-				case "sha384":
-					hashAlg = sha512.New384
-				case "sha512":
-					hashAlg = sha512.New
-				default:
+				gotAlg, ok := parseHashAlg(signature[0])
+				if !ok {
 					log.Printf("HashAlg:%s is unknown. %s", signature[0], s)
+					client.feedFor(s.topic).sendErr(fmt.Errorf("unknown signature algorithm %q for %s", signature[0], s.topic))
 					http.Error(resp, "Invalid Signature", http.StatusBadRequest)
 					return
 				}
+				if gotAlg.strength() < s.algorithm.strength() {
+					log.Printf("Signature algorithm %s weaker than required %s, %s", gotAlg, s.algorithm, s)
+					client.feedFor(s.topic).sendErr(fmt.Errorf("signature algorithm %s weaker than required %s for %s", gotAlg, s.algorithm, s.topic))
+					http.Error(resp, "Signature algorithm too weak", http.StatusBadRequest)
+					return
+				}
 
-				mac := hmac.New(hashAlg, []byte(s.SecretKey()))
+				mac := hmac.New(gotAlg.hashFunc(), []byte(s.SecretKey()))
 				mac.Write([]byte(requestBody))
 				sum := hex.EncodeToString(mac.Sum(nil))
 
 				if !strings.EqualFold(signature[1], sum) {
 					log.Printf("Signature mismatch [%s][%s] %s", signature[1], sum, s)
+					client.feedFor(s.topic).sendErr(fmt.Errorf("signature mismatch for %s", s.topic))
 					http.Error(resp, "Invalid Signature", http.StatusBadRequest)
 					return
 				}
 			}
 
-			// Asynchronously notify the subscription handler, shouldn't affect response.
-			go client.broadcast(s, req.Header.Get("Content-Type"), requestBody)
+			// Asynchronously notify subscribers, shouldn't affect response.
+			var hubLink, selfLink string
+			for _, l := range parseLinkHeader(req.Header.Get("Link")) {
+				switch l.Rel {
+				case "hub":
+					hubLink = l.Href
+				case "self":
+					selfLink = l.Href
+				}
+			}
+			go client.broadcast(s, req.Header.Get("Content-Type"), requestBody, hubLink, selfLink)
 		}
 	}
 
@@ -387,7 +699,7 @@ func (client *Client) subscriptionForPath(path string) (*subscription, bool) {
 	if err != nil {
 		return nilSubscription, false
 	}
-	for _, s := range client.subscriptions {
+	for _, s := range client.store.List() {
 		if s.id == id {
 			return s, true
 		}
@@ -395,26 +707,28 @@ func (client *Client) subscriptionForPath(path string) (*subscription, bool) {
 	return nilSubscription, false
 }
 
-// broadcast dispatches the body of a message to the subscription handler, but
-// only if it isn't a duplicate.
-func (client *Client) broadcast(s *subscription, contentType string, body []byte) {
-	hash := md5.New().Sum(body)
+// broadcast dispatches the body of a message to every Subscription on the
+// topic's feed, but only if it isn't a duplicate.
+func (client *Client) broadcast(s *subscription, contentType string, body []byte, hubLink, selfLink string) {
+	digest := sha256.New()
+	digest.Write([]byte(s.topic))
+	digest.Write([]byte{0})
+	digest.Write([]byte(contentType))
+	digest.Write([]byte{0})
+	digest.Write(body)
+
+	if client.dedupFor(s.topic).Seen(hex.EncodeToString(digest.Sum(nil))) {
+		log.Printf("Dropping duplicate update for %s", s)
+		return
+	}
 
-	// TODO: Use expiring cache if history size increases to handle higher message
-	// throughputs.
-	unique := true
-	client.history.Do(func(v interface{}) {
-		b, ok := v.([]byte)
-		if ok && bytes.Equal(hash, b) {
-			unique = false
-		}
+	client.feedFor(s.topic).send(Update{
+		Topic:       s.topic,
+		ContentType: contentType,
+		Body:        body,
+		HubLink:     hubLink,
+		SelfLink:    selfLink,
 	})
-
-	if unique {
-		client.history.Value = hash
-		client.history = client.history.Next()
-		s.handler(contentType, body)
-	}
 }
 
 // Protocol cheat sheet:
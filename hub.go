@@ -0,0 +1,460 @@
+package gohubbub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseSeconds is the lease duration a Hub grants a subscriber that
+// doesn't request one of its own.
+const DefaultLeaseSeconds = 10 * 24 * 60 * 60 // 10 days.
+
+// TopicRepository stores the topic URLs a Hub knows how to publish.
+type TopicRepository interface {
+	// Add registers topic as publishable, if it isn't already.
+	Add(topic string) error
+	// Has reports whether topic has been registered.
+	Has(topic string) bool
+	// List returns every registered topic.
+	List() []string
+}
+
+// HubSubscription is a single subscriber's lease on a topic, as tracked by a
+// Hub.
+type HubSubscription struct {
+	Callback  string
+	Topic     string
+	Secret    string
+	Algorithm string // sha1, sha256, sha384 or sha512; empty means sha1. Unsigned if Secret is empty.
+	ExpiresAt time.Time
+}
+
+// SubscriptionRepository stores the subscriptions a Hub has verified.
+type SubscriptionRepository interface {
+	// Put saves (or updates) s.
+	Put(s HubSubscription) error
+	// Delete removes the subscription for callback/topic, if any.
+	Delete(callback, topic string) error
+	// ListByTopic returns every subscription currently held on topic.
+	ListByTopic(topic string) []HubSubscription
+	// ListExpired returns every subscription whose lease has expired as of
+	// now.
+	ListExpired(now time.Time) []HubSubscription
+}
+
+// memoryTopicRepository is an in-memory TopicRepository.
+type memoryTopicRepository struct {
+	mu     sync.Mutex
+	topics map[string]struct{}
+}
+
+// NewMemoryTopicRepository returns a TopicRepository backed by an in-memory
+// map, suitable for tests or single-process hubs.
+func NewMemoryTopicRepository() TopicRepository {
+	return &memoryTopicRepository{topics: make(map[string]struct{})}
+}
+
+func (r *memoryTopicRepository) Add(topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics[topic] = struct{}{}
+	return nil
+}
+
+func (r *memoryTopicRepository) Has(topic string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.topics[topic]
+	return ok
+}
+
+func (r *memoryTopicRepository) List() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.topics))
+	for t := range r.topics {
+		out = append(out, t)
+	}
+	return out
+}
+
+// memorySubscriptionRepository is an in-memory SubscriptionRepository.
+type memorySubscriptionRepository struct {
+	mu   sync.Mutex
+	subs map[string]HubSubscription
+}
+
+// NewMemorySubscriptionRepository returns a SubscriptionRepository backed by
+// an in-memory map, suitable for tests or single-process hubs.
+func NewMemorySubscriptionRepository() SubscriptionRepository {
+	return &memorySubscriptionRepository{subs: make(map[string]HubSubscription)}
+}
+
+func subKey(topic, callback string) string {
+	return topic + "|" + callback
+}
+
+func (r *memorySubscriptionRepository) Put(s HubSubscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs[subKey(s.Topic, s.Callback)] = s
+	return nil
+}
+
+func (r *memorySubscriptionRepository) Delete(callback, topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subs, subKey(topic, callback))
+	return nil
+}
+
+func (r *memorySubscriptionRepository) ListByTopic(topic string) []HubSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []HubSubscription
+	for _, s := range r.subs {
+		if s.Topic == topic {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (r *memorySubscriptionRepository) ListExpired(now time.Time) []HubSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []HubSubscription
+	for _, s := range r.subs {
+		if now.After(s.ExpiresAt) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Hub implements the publisher/hub half of WebSub 0.4: it accepts
+// hub.mode=subscribe|unsubscribe|publish form posts, asynchronously
+// verifies subscribers with a random hub.challenge, enforces lease
+// expiry, and fans out topic content signed with each subscriber's
+// hub.secret.
+type Hub struct {
+	topics        TopicRepository
+	subscriptions SubscriptionRepository
+	httpRequester HttpRequester
+
+	dedup DedupCache // Seen (topic, body) digests; bounded, unlike a plain map.
+
+	sweepStop chan struct{}
+}
+
+// NewHub creates a Hub backed by the given repositories, e.g.
+// NewMemoryTopicRepository and NewMemorySubscriptionRepository for a
+// purely in-memory hub. Published content is deduplicated with an LRU sized
+// DefaultDedupCacheSize/DefaultDedupTTL; use NewHubWithDedupCache to
+// customize that.
+func NewHub(topics TopicRepository, subscriptions SubscriptionRepository) *Hub {
+	return NewHubWithDedupCache(topics, subscriptions, newLRUDedupCache(DefaultDedupCacheSize, DefaultDedupTTL))
+}
+
+// NewHubWithDedupCache creates a Hub like NewHub, but with a caller-supplied
+// DedupCache for deduplicating published content.
+func NewHubWithDedupCache(topics TopicRepository, subscriptions SubscriptionRepository, dedup DedupCache) *Hub {
+	return &Hub{
+		topics:        topics,
+		subscriptions: subscriptions,
+		httpRequester: &http.Client{},
+		dedup:         dedup,
+	}
+}
+
+// RegisterHandler binds the Hub's endpoint to path on mux.
+func (hub *Hub) RegisterHandler(mux *http.ServeMux, path string) {
+	mux.HandleFunc(path, hub.handleRequest)
+}
+
+// StartSweeper starts a goroutine that removes expired subscriptions every
+// interval, until StopSweeper is called.
+func (hub *Hub) StartSweeper(interval time.Duration) {
+	hub.sweepStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hub.sweepExpired()
+			case <-hub.sweepStop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper stops a sweeper started with StartSweeper.
+func (hub *Hub) StopSweeper() {
+	if hub.sweepStop != nil {
+		close(hub.sweepStop)
+	}
+}
+
+func (hub *Hub) sweepExpired() {
+	for _, s := range hub.subscriptions.ListExpired(time.Now()) {
+		log.Printf("Subscription lease expired for %s -> %s", s.Topic, s.Callback)
+		hub.subscriptions.Delete(s.Callback, s.Topic)
+	}
+}
+
+func (hub *Hub) handleRequest(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(resp, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	switch req.PostForm.Get("hub.mode") {
+	case "subscribe":
+		hub.handleSubscribe(resp, req)
+	case "unsubscribe":
+		hub.handleUnsubscribe(resp, req)
+	case "publish":
+		hub.handlePublish(resp, req)
+	default:
+		http.Error(resp, "Unknown hub.mode", http.StatusBadRequest)
+	}
+}
+
+func (hub *Hub) handleSubscribe(resp http.ResponseWriter, req *http.Request) {
+	callback := req.PostForm.Get("hub.callback")
+	topic := req.PostForm.Get("hub.topic")
+	if callback == "" || topic == "" {
+		http.Error(resp, "hub.callback and hub.topic are required", http.StatusBadRequest)
+		return
+	}
+
+	leaseSeconds := DefaultLeaseSeconds
+	if ls, err := strconv.Atoi(req.PostForm.Get("hub.lease_seconds")); err == nil && ls > 0 {
+		leaseSeconds = ls
+	}
+
+	hub.topics.Add(topic)
+
+	s := HubSubscription{
+		Callback:  callback,
+		Topic:     topic,
+		Secret:    req.PostForm.Get("hub.secret"),
+		Algorithm: req.PostForm.Get("hub.secret.algorithm"),
+		ExpiresAt: time.Now().Add(time.Duration(leaseSeconds) * time.Second),
+	}
+
+	resp.WriteHeader(http.StatusAccepted)
+	go hub.verify(s, "subscribe", leaseSeconds)
+}
+
+func (hub *Hub) handleUnsubscribe(resp http.ResponseWriter, req *http.Request) {
+	callback := req.PostForm.Get("hub.callback")
+	topic := req.PostForm.Get("hub.topic")
+	if callback == "" || topic == "" {
+		http.Error(resp, "hub.callback and hub.topic are required", http.StatusBadRequest)
+		return
+	}
+
+	s := HubSubscription{Callback: callback, Topic: topic}
+	resp.WriteHeader(http.StatusAccepted)
+	go hub.verify(s, "unsubscribe", 0)
+}
+
+// verify performs the asynchronous GET against the subscriber's callback
+// required by WebSub, using a random hub.challenge, and only commits the
+// (un)subscription once the subscriber echoes it back with a 2xx response.
+func (hub *Hub) verify(s HubSubscription, mode string, leaseSeconds int) {
+	challenge, err := randomChallenge()
+	if err != nil {
+		log.Printf("Unable to generate challenge for %s, %v", s.Callback, err)
+		return
+	}
+
+	q := url.Values{}
+	q.Set("hub.mode", mode)
+	q.Set("hub.topic", s.Topic)
+	q.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		q.Set("hub.lease_seconds", strconv.Itoa(leaseSeconds))
+	}
+
+	verifyURL := s.Callback
+	if strings.Contains(verifyURL, "?") {
+		verifyURL += "&" + q.Encode()
+	} else {
+		verifyURL += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequest("GET", verifyURL, nil)
+	if err != nil {
+		log.Printf("Unable to build verification request for %s, %v", s.Callback, err)
+		return
+	}
+
+	resp, err := hub.httpRequester.Do(req)
+	if err != nil {
+		log.Printf("Verification request failed for %s, %v", s.Callback, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 || string(body) != challenge {
+		log.Printf("Verification failed for %s %s", mode, s.Callback)
+		return
+	}
+
+	if mode == "subscribe" {
+		if err := hub.subscriptions.Put(s); err != nil {
+			log.Printf("Unable to store subscription for %s, %v", s.Callback, err)
+		}
+	} else if err := hub.subscriptions.Delete(s.Callback, s.Topic); err != nil {
+		log.Printf("Unable to remove subscription for %s, %v", s.Callback, err)
+	}
+}
+
+// randomChallenge returns a random alphanumeric string between 16 and 32
+// characters long, matching the challenge lengths used by the toby3d/hub
+// verification flow.
+func randomChallenge() (string, error) {
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	n, err := rand.Int(rand.Reader, big.NewInt(17))
+	if err != nil {
+		return "", err
+	}
+	length := 16 + int(n.Int64())
+
+	b := make([]byte, length)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = chars[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+func (hub *Hub) handlePublish(resp http.ResponseWriter, req *http.Request) {
+	topic := req.PostForm.Get("hub.url")
+	if topic == "" {
+		topic = req.PostForm.Get("hub.topic")
+	}
+	if topic == "" || !hub.topics.Has(topic) {
+		http.Error(resp, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+
+	resp.WriteHeader(http.StatusAccepted)
+	go hub.fetchAndDeliver(topic)
+}
+
+// fetchAndDeliver fetches topic's current content and, unless the hub's
+// DedupCache has already seen the same (topic, body) digest, signs and
+// delivers it to every subscriber.
+func (hub *Hub) fetchAndDeliver(topic string) {
+	req, err := http.NewRequest("GET", topic, nil)
+	if err != nil {
+		log.Printf("Unable to build fetch request for %s, %v", topic, err)
+		return
+	}
+
+	resp, err := hub.httpRequester.Do(req)
+	if err != nil {
+		log.Printf("Unable to fetch topic %s, %v", topic, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Unable to read topic %s, %v", topic, err)
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	digest := sha256.Sum256(append([]byte(topic), body...))
+	key := hex.EncodeToString(digest[:])
+
+	if hub.dedup.Seen(key) {
+		log.Printf("Skipping duplicate publish for %s", topic)
+		return
+	}
+
+	for _, s := range hub.subscriptions.ListByTopic(topic) {
+		hub.deliver(s, contentType, body)
+	}
+}
+
+func (hub *Hub) deliver(s HubSubscription, contentType string, body []byte) {
+	req, err := http.NewRequest("POST", s.Callback, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Unable to build delivery request for %s, %v", s.Callback, err)
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Link", fmt.Sprintf(`<%s>; rel="hub", <%s>; rel="self"`, s.Topic, s.Topic))
+
+	if s.Secret != "" {
+		sig, alg, err := signBody(s.Secret, s.Algorithm, body)
+		if err != nil {
+			log.Printf("Unable to sign payload for %s, %v", s.Callback, err)
+			return
+		}
+		req.Header.Set("X-Hub-Signature", fmt.Sprintf("%s=%s", alg, sig))
+	}
+
+	resp, err := hub.httpRequester.Do(req)
+	if err != nil {
+		log.Printf("Delivery failed for %s, %v", s.Callback, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// signBody computes the HMAC of body under secret using algorithm (falling
+// back to sha1 per https://www.w3.org/TR/websub/#recognized-algorithm-names
+// if algorithm is empty), returning the hex-encoded signature and the
+// canonical algorithm name it was computed with.
+func signBody(secret, algorithm string, body []byte) (signature, alg string, err error) {
+	var hashAlg func() hash.Hash
+	switch algorithm {
+	case "", "sha1":
+		hashAlg, alg = sha1.New, "sha1"
+	case "sha256":
+		hashAlg, alg = sha256.New, "sha256"
+	case "sha384":
+		hashAlg, alg = sha512.New384, "sha384"
+	case "sha512":
+		hashAlg, alg = sha512.New, "sha512"
+	default:
+		return "", "", fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+
+	mac := hmac.New(hashAlg, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil)), alg, nil
+}
@@ -0,0 +1,156 @@
+package gohubbub
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SubscriptionState describes where a subscription is in its lifecycle, as
+// tracked by the renewal scheduler.
+type SubscriptionState int
+
+const (
+	// StatePending means a subscribe request has been sent (or is about to
+	// be) but the hub hasn't verified it yet.
+	StatePending SubscriptionState = iota
+	// StateActive means the hub has verified the subscription and it's
+	// within its lease.
+	StateActive
+	// StateDenied means the hub sent a "denied" notification; the
+	// subscription is no longer retried automatically and needs user
+	// intervention (e.g. Unsubscribe followed by a fresh Subscribe).
+	StateDenied
+)
+
+func (s SubscriptionState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateActive:
+		return "active"
+	case StateDenied:
+		return "denied"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	minRenewalBackoff = 5 * time.Second
+	maxRenewalBackoff = 30 * time.Minute
+
+	// renewalJitter is subtracted from a lease's expiry so a subscription
+	// renews before the hub considers it expired.
+	renewalJitter = time.Minute
+
+	// verificationTimeout is the minimum time we wait for a hub's
+	// verification GET before retrying the subscribe request; the wait is
+	// longer when the backoff for the current retry count exceeds it.
+	verificationTimeout = 2 * time.Minute
+)
+
+// backoffDuration returns an exponential backoff with jitter for the given
+// retry count, capped at maxRenewalBackoff.
+func backoffDuration(retries int) time.Duration {
+	d := minRenewalBackoff
+	for i := 0; i < retries && d < maxRenewalBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRenewalBackoff {
+		d = maxRenewalBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// parseRetryAfter parses a Retry-After header value, which may be either a
+// number of seconds or an HTTP date. It returns 0 if the header is absent
+// or unparseable.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// scheduleRenewal cancels any pending timer on s and arranges for
+// client.renew(s) to run after delay.
+func (client *Client) scheduleRenewal(s *subscription, delay time.Duration) {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(delay, func() { client.renew(s) })
+	s.mu.Unlock()
+}
+
+// renew (re-)sends a subscribe request for s, unless it's been denied.
+func (client *Client) renew(s *subscription) {
+	s.mu.Lock()
+	denied := s.state == StateDenied
+	s.mu.Unlock()
+	if denied {
+		return
+	}
+	client.makeSubscriptionRequest(s)
+}
+
+// recordFailure tracks a failed subscribe attempt and schedules a retry,
+// honoring the hub's Retry-After header if it sent one, otherwise backing
+// off exponentially with jitter.
+func (client *Client) recordFailure(s *subscription, err error, retryAfter time.Duration) {
+	s.mu.Lock()
+	s.lastError = err
+	s.retries++
+	retries := s.retries
+	s.mu.Unlock()
+
+	client.feedFor(s.topic).sendErr(err)
+
+	delay := retryAfter
+	if delay <= 0 {
+		delay = backoffDuration(retries)
+	}
+	client.scheduleRenewal(s, delay)
+}
+
+// SubscriptionInfo is a point-in-time snapshot of a subscription, returned
+// by Client.Subscriptions for observability.
+type SubscriptionInfo struct {
+	Hub        string
+	Topic      string
+	Lease      time.Duration
+	VerifiedAt time.Time
+	State      SubscriptionState
+	LastError  error
+}
+
+// Subscriptions returns a snapshot of every subscription's current lease,
+// verification time, state and last error, for monitoring and debugging
+// against flaky hubs.
+func (client *Client) Subscriptions() []SubscriptionInfo {
+	subs := client.store.List()
+	out := make([]SubscriptionInfo, 0, len(subs))
+	for _, s := range subs {
+		s.mu.Lock()
+		out = append(out, SubscriptionInfo{
+			Hub:        s.hub,
+			Topic:      s.topic,
+			Lease:      s.lease,
+			VerifiedAt: s.verifiedAt,
+			State:      s.state,
+			LastError:  s.lastError,
+		})
+		s.mu.Unlock()
+	}
+	return out
+}
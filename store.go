@@ -0,0 +1,221 @@
+package gohubbub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SubscriptionStore persists subscription state so that lease timers,
+// secrets and callback IDs survive process restarts and can be shared
+// across replicas, rather than living only in the Client's in-memory map.
+type SubscriptionStore interface {
+	// Get returns the subscription registered for topic, if any.
+	Get(topic string) (*subscription, bool)
+	// Put saves (or updates) the subscription for its topic.
+	Put(s *subscription) error
+	// Delete removes the subscription for topic.
+	Delete(topic string) error
+	// List returns every currently stored subscription.
+	List() []*subscription
+	// NextID returns a new, store-unique callback ID.
+	NextID() int
+}
+
+// memoryStore is the default in-memory SubscriptionStore. It matches the
+// behaviour Client had before stores were pluggable: state is lost on
+// restart and IDs are never reused within a process.
+type memoryStore struct {
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	nextID        int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{subscriptions: make(map[string]*subscription)}
+}
+
+func (m *memoryStore) Get(topic string) (*subscription, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.subscriptions[topic]
+	return s, ok
+}
+
+func (m *memoryStore) Put(s *subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptions[s.topic] = s
+	return nil
+}
+
+func (m *memoryStore) Delete(topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subscriptions, topic)
+	return nil
+}
+
+func (m *memoryStore) List() []*subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*subscription, 0, len(m.subscriptions))
+	for _, s := range m.subscriptions {
+		out = append(out, s)
+	}
+	return out
+}
+
+func (m *memoryStore) NextID() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextID
+	m.nextID++
+	return id
+}
+
+// fileStoreRecord is the on-disk representation of a subscription. Handler
+// funcs can't be serialized, so callers must re-Subscribe for each topic
+// after loading a file store to reattach them; the lease, secret and
+// callback ID are preserved so the resulting subscribe request can reuse the
+// existing callback URL instead of registering a new one with the hub.
+type fileStoreRecord struct {
+	Hub          string            `json:"hub"`
+	Topic        string            `json:"topic"`
+	Secret       string            `json:"secret"`
+	ID           int               `json:"id"`
+	Algorithm    HashAlg           `json:"algorithm"`
+	LeaseSeconds int               `json:"leaseSeconds"`
+	Callback     string            `json:"callback"`
+	Lease        time.Duration     `json:"lease"`
+	VerifiedAt   time.Time         `json:"verifiedAt"`
+	State        SubscriptionState `json:"state"`
+}
+
+// fileStoreData is the top-level document written to a file store's path.
+type fileStoreData struct {
+	NextID  int               `json:"nextId"`
+	Records []fileStoreRecord `json:"records"`
+}
+
+// fileStore is a SubscriptionStore backed by a JSON file, rewritten on every
+// mutation. It's intended for single-process deployments that want
+// subscriptions to survive a restart without standing up a database.
+type fileStore struct {
+	path   string
+	mem    *memoryStore
+	saveMu sync.Mutex // Serializes save() so concurrent mutations can't interleave writes.
+}
+
+// NewFileSubscriptionStore returns a SubscriptionStore that persists to the
+// JSON file at path, loading any existing state immediately.
+func NewFileSubscriptionStore(path string) (SubscriptionStore, error) {
+	fs := &fileStore{path: path, mem: newMemoryStore()}
+	if err := fs.load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to load subscription store, %v", err)
+	}
+	return fs, nil
+}
+
+func (fs *fileStore) Get(topic string) (*subscription, bool) {
+	return fs.mem.Get(topic)
+}
+
+func (fs *fileStore) List() []*subscription {
+	return fs.mem.List()
+}
+
+func (fs *fileStore) Put(s *subscription) error {
+	if err := fs.mem.Put(s); err != nil {
+		return err
+	}
+	return fs.save()
+}
+
+func (fs *fileStore) Delete(topic string) error {
+	if err := fs.mem.Delete(topic); err != nil {
+		return err
+	}
+	return fs.save()
+}
+
+func (fs *fileStore) NextID() int {
+	id := fs.mem.NextID()
+	if err := fs.save(); err != nil {
+		log.Printf("Unable to persist subscription store, %v", err)
+	}
+	return id
+}
+
+func (fs *fileStore) load() error {
+	data, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		return err
+	}
+
+	var d fileStoreData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	fs.mem.nextID = d.NextID
+	for _, r := range d.Records {
+		fs.mem.subscriptions[r.Topic] = &subscription{
+			hub:          r.Hub,
+			topic:        r.Topic,
+			secret:       r.Secret,
+			id:           r.ID,
+			algorithm:    r.Algorithm,
+			leaseSeconds: r.LeaseSeconds,
+			callback:     r.Callback,
+			lease:        r.Lease,
+			verifiedAt:   r.VerifiedAt,
+			state:        r.State,
+		}
+	}
+	return nil
+}
+
+// save serializes the current state to fs.path. It's called after every
+// mutation, so it's guarded by saveMu to stop two concurrent mutations (e.g.
+// two subscriptions verifying around the same moment) from interleaving
+// their writes, and writes via a temp file + rename so a reader never
+// observes a partially-written file.
+func (fs *fileStore) save() error {
+	fs.saveMu.Lock()
+	defer fs.saveMu.Unlock()
+
+	d := fileStoreData{NextID: fs.mem.nextID}
+	for _, s := range fs.mem.List() {
+		s.mu.Lock()
+		record := fileStoreRecord{
+			Hub:          s.hub,
+			Topic:        s.topic,
+			Secret:       s.secret,
+			ID:           s.id,
+			Algorithm:    s.algorithm,
+			LeaseSeconds: s.leaseSeconds,
+			Callback:     s.callback,
+			Lease:        s.lease,
+			VerifiedAt:   s.verifiedAt,
+			State:        s.state,
+		}
+		s.mu.Unlock()
+		d.Records = append(d.Records, record)
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fs.path)
+}